@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net"
+
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+
+	"github.com/adhocteam/ec2ssh/resolver"
+)
+
+var bastionFlag string
+
+const bastionTag = "Ec2Ssh:Bastion"
+
+func init() {
+	flag.StringVar(&bastionFlag, "bastion", "", "name or id of a bastion host to ProxyJump through")
+}
+
+// resolveProxyJump decides whether instance (found in region) needs a
+// bastion to reach it and, if so, resolves that bastion and returns the
+// "user@host" value for ssh's ProxyJump option. It returns "" when no
+// bastion is needed. It is a no-op for the SSM transport, which bypasses
+// bastions entirely (the caller only invokes it for *SSHTransport).
+func resolveProxyJump(ctx context.Context, instance *ec2.Instance, region string, clients map[string]resolver.EC2Client, cache *imageCache, cfg *ec2sshConfig) (string, error) {
+	query := bastionFlag
+	if query == "" {
+		query, _ = instanceTag(instance, bastionTag)
+	}
+	if query == "" {
+		if !needsBastion(instance) {
+			return "", nil
+		}
+		if instance.VpcId == nil {
+			return "", nil
+		}
+		query = cfg.Bastions[*instance.VpcId]
+		if query == "" {
+			return "", nil
+		}
+		debugf("no reachable private IP for %s, using configured bastion %q for vpc %s", *instance.InstanceId, query, *instance.VpcId)
+	}
+
+	params := resolver.LookupParams(query)
+
+	// The bastion naming convention (tag or Bastions config) is commonly
+	// shared across regions, so search the target's own region first
+	// before falling back to a full multi-region search.
+	regionalResults, total := describeBastionCandidates(ctx, map[string]resolver.EC2Client{region: clients[region]}, params)
+	if total == 0 {
+		regionalResults, total = describeBastionCandidates(ctx, clients, params)
+	}
+
+	if total == 0 {
+		return "", fmt.Errorf("bastion %q: %s", query, fmt.Errorf("Found no instance '%s'", query))
+	}
+	if total > 1 {
+		return "", fmt.Errorf("bastion %q matches more than one instance", query)
+	}
+
+	bastion, bastionRegion := resolver.FindFirstInstance(regionalResults)
+
+	bastionIP := ""
+	if bastion.PublicIpAddress != nil {
+		bastionIP = *bastion.PublicIpAddress
+	} else if bastion.PrivateIpAddress != nil {
+		bastionIP = *bastion.PrivateIpAddress
+	} else {
+		return "", fmt.Errorf("bastion %q has no reachable IP address", query)
+	}
+
+	bastionUser := resolveUser(bastion, bastionRegion, cache, cfg)
+
+	return fmt.Sprintf("%s@%s", bastionUser, bastionIP), nil
+}
+
+// describeBastionCandidates runs params against clients and returns the
+// regional results along with the total number of matching instances,
+// logging any per-region errors.
+func describeBastionCandidates(ctx context.Context, clients map[string]resolver.EC2Client, params *ec2.DescribeInstancesInput) ([]resolver.RegionalReservations, int) {
+	regionalResults, errs := resolver.DescribeAllRegions(ctx, clients, params)
+	for _, err := range errs {
+		debugf("aws api: %s", err)
+	}
+
+	total := 0
+	for _, region := range regionalResults {
+		for _, reservation := range region.Reservations {
+			total += len(reservation.Instances)
+		}
+	}
+	return regionalResults, total
+}
+
+// needsBastion heuristically decides whether instance is unreachable
+// directly: it has no public IP, and none of the caller's local interface
+// addresses share a private (RFC1918) network with the instance's private
+// IP.
+func needsBastion(instance *ec2.Instance) bool {
+	if instance.PublicIpAddress != nil {
+		return false
+	}
+	if instance.PrivateIpAddress == nil {
+		return false
+	}
+	return !callerSharesNetwork(*instance.PrivateIpAddress)
+}
+
+func callerSharesNetwork(target string) bool {
+	targetIP := net.ParseIP(target)
+	if targetIP == nil || !targetIP.IsPrivate() {
+		return false
+	}
+
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		debugf("listing local interfaces failed: %s", err)
+		return false
+	}
+
+	for _, addr := range addrs {
+		ipNet, ok := addr.(*net.IPNet)
+		if !ok || ipNet.IP.To4() == nil || !ipNet.IP.IsPrivate() {
+			continue
+		}
+		if ipNet.Contains(targetIP) {
+			return true
+		}
+	}
+	return false
+}
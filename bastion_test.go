@@ -0,0 +1,59 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+)
+
+func TestNeedsBastion(t *testing.T) {
+	cases := []struct {
+		name     string
+		instance *ec2.Instance
+		want     bool
+	}{
+		{
+			name:     "has a public IP",
+			instance: &ec2.Instance{PublicIpAddress: aws.String("203.0.113.5")},
+			want:     false,
+		},
+		{
+			name:     "no IPs at all",
+			instance: &ec2.Instance{},
+			want:     false,
+		},
+		{
+			name:     "private IP outside any local network",
+			instance: &ec2.Instance{PrivateIpAddress: aws.String("10.99.99.99")},
+			want:     true,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := needsBastion(c.instance); got != c.want {
+				t.Fatalf("needsBastion() = %v, want %v", got, c.want)
+			}
+		})
+	}
+}
+
+func TestCallerSharesNetwork(t *testing.T) {
+	cases := []struct {
+		name   string
+		target string
+		want   bool
+	}{
+		{"not an IP", "not-an-ip", false},
+		{"public IP", "203.0.113.5", false},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := callerSharesNetwork(c.target); got != c.want {
+				t.Fatalf("callerSharesNetwork(%q) = %v, want %v", c.target, got, c.want)
+			}
+		})
+	}
+}
@@ -1,28 +1,21 @@
 package main
 
 import (
-	"bytes"
-	"errors"
+	"context"
 	"flag"
 	"fmt"
-	"io"
 	"log"
-	"net"
-	"net/url"
 	"os"
-	"os/exec"
 	"path"
 	"path/filepath"
-	"regexp"
-	"sort"
-	"strconv"
 	"strings"
-	"text/tabwriter"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/aws/awserr"
 	"github.com/aws/aws-sdk-go-v2/aws/external"
 	"github.com/aws/aws-sdk-go-v2/service/ec2"
+
+	"github.com/adhocteam/ec2ssh/resolver"
 )
 
 func usage() {
@@ -33,6 +26,14 @@ Options:
   -p	        path to SSH key files
   -l, --list    list running and pending AWS instances
   -c, --command run a command on the remote server
+  -r	        comma-separated list of regions to search (default: $AWS_REGIONS or all enabled regions)
+  --via         transport to use: ssh or ssm (default: auto)
+  --ssm-forward localPort:remotePort to forward over an SSM session
+  --eic         push an ephemeral key via EC2 Instance Connect instead of a .pem file
+  -o, --output  output format for -l: table (default), wide, json, csv
+  -u, --user    SSH user to connect as (default: resolved from tags/config/AMI)
+  --print-user  print the resolved SSH user and exit, without connecting
+  --bastion     name or id of a bastion host to ProxyJump through (default: auto)
 `, filepath.Base(os.Args[0]))
 	os.Exit(1)
 }
@@ -41,34 +42,7 @@ var verboseFlag bool
 var remoteCommand string
 var listInstances bool
 var kp string
-
-var instIdRe = regexp.MustCompile(`i-[0-9a-fA-F]{8,17}$`)
-
-type Instance struct {
-	Name string
-	Id   string
-	Ip   string
-}
-
-type Instances []*Instance
-
-func (s Instances) Len() int {
-	return len(s)
-}
-
-func (s Instances) Swap(i, j int) {
-	s[i], s[j] = s[j], s[i]
-}
-
-func (s Instances) Less(i, j int) bool {
-	switch strings.Compare(s[i].Name, s[j].Name) {
-	case -1:
-		return true
-	case 1:
-		return false
-	}
-	return s[i].Name > s[j].Name
-}
+var regionsFlag string
 
 func debugf(format string, args ...interface{}) {
 	if verboseFlag {
@@ -85,50 +59,6 @@ func printError(err error) {
 	os.Exit(1)
 }
 
-func reservationsToInstances(reservations []ec2.RunInstancesOutput) []*Instance {
-	var instances []*Instance
-	for _, reservation := range reservations {
-		for _, instance := range reservation.Instances {
-			name := "[None]"
-			for _, keys := range instance.Tags {
-				if *keys.Key == "Name" {
-					name = url.QueryEscape(*keys.Value)
-				}
-			}
-			instances = append(instances, &Instance{Name: name, Id: *instance.InstanceId, Ip: *instance.PrivateIpAddress})
-		}
-	}
-	sort.Sort(Instances(instances))
-	return instances
-}
-
-func printInstanceList(instances []*Instance) {
-	if len(instances) == 0 {
-		printError(errors.New("Found no instances"))
-	} else {
-		writer := tabwriter.NewWriter(os.Stdout, 4, 4, 4, ' ', tabwriter.TabIndent)
-		fmt.Fprintln(writer, "Name\tInstance ID\tPrivate IP")
-		fmt.Fprintln(writer, "----\t-----------\t----------")
-		for _, instance := range instances {
-			fmt.Fprintf(writer, "%s\t%s\t%s\n", instance.Name, instance.Id, instance.Ip)
-		}
-		writer.Flush()
-	}
-}
-
-// Formats a slice of instance pointers into a table and returns it
-func fmtInstanceList(instances []*Instance) string {
-	var buf bytes.Buffer
-	writer := tabwriter.NewWriter(&buf, 4, 4, 4, ' ', tabwriter.TabIndent)
-	fmt.Fprintln(writer, "n\tName\tInstance ID\tPrivate IP")
-	fmt.Fprintln(writer, "-\t----\t-----------\t----------")
-	for i, instance := range instances {
-		fmt.Fprintf(writer, "%d\t%s\t%s\t%s\n", i+1, instance.Name, instance.Id, instance.Ip)
-	}
-	writer.Flush()
-	return buf.String()
-}
-
 func init() {
 	// default key path to home dir, inherit if env var if set
 	p := os.Getenv("HOME") + "/.ssh/"
@@ -144,62 +74,63 @@ func init() {
 
 	flag.StringVar(&remoteCommand, "c", "", "A command to run on the remote server")
 	flag.StringVar(&remoteCommand, "command", "", "A command to run on the remote server")
-}
 
-// Given an instance name and Id, and a reservation list, return the ec2.Instance
-// that matches
-func findInstance(instance *Instance, reservations []ec2.RunInstancesOutput) (*ec2.Instance, error) {
-	for _, reservation := range reservations {
-		for _, ec2Instance := range reservation.Instances {
-			if *ec2Instance.InstanceId == instance.Id {
-				return &ec2Instance, nil
-			}
-		}
-	}
-	return nil, fmt.Errorf("Unable to find instance %#v", instance)
-}
+	flag.StringVar(&regionsFlag, "r", os.Getenv("AWS_REGIONS"), "comma-separated list of regions to search, default is all enabled regions")
 
-// Accepts the user's query and a slice of reservations that match the query.
-// Shows the user the instance IDs and allows them to choose one on the command
-// line, and returns a pointer to the instance that was chosen
-func chooseInstance(lookup string, reservations []ec2.RunInstancesOutput) *ec2.Instance {
-	var instanceList = reservationsToInstances(reservations)
-
-	fmt.Printf(`Found more than one instance for '%s'.
-
-Available instances:
+	flag.StringVar(&outputFlag, "o", "table", "output format for -l: table, wide, json, csv")
+	flag.StringVar(&outputFlag, "output", "table", "output format for -l: table, wide, json, csv")
+}
 
-%s
+// ec2ClientAdapter adapts the AWS SDK's request-builder style EC2 client to
+// the plain resolver.EC2Client interface.
+type ec2ClientAdapter struct {
+	svc *ec2.Client
+}
 
-Which would you like to connect to? [1]
->>> `, lookup, fmtInstanceList(instanceList))
-	var which string
-	_, err := fmt.Scanln(&which)
-	if err == io.EOF {
-		// We're currently in the middle of a line; print a newline to clean up
-		// the user's terminal
-		fmt.Println("")
-		os.Exit(0)
-	}
+func (a *ec2ClientAdapter) DescribeInstances(ctx context.Context, input *ec2.DescribeInstancesInput) (*ec2.DescribeInstancesOutput, error) {
+	req := a.svc.DescribeInstancesRequest(input)
+	return req.Send()
+}
 
-	idx := 1
-	if len(which) > 0 {
-		idx, err = strconv.Atoi(which)
-		if err != nil {
-			printError(err)
+// resolveRegions returns the regions ec2ssh should search, in order of
+// precedence: the -r flag (and its AWS_REGIONS env var default), or else
+// every region enabled for the account.
+func resolveRegions(cfg aws.Config) ([]string, error) {
+	if regionsFlag != "" {
+		var regions []string
+		for _, r := range strings.Split(regionsFlag, ",") {
+			if r = strings.TrimSpace(r); r != "" {
+				regions = append(regions, r)
+			}
 		}
+		return regions, nil
 	}
 
-	if idx < 1 || idx > len(instanceList) {
-		printError(fmt.Errorf("Invalid index %d", idx))
+	debugf("aws api: describing enabled regions")
+	svc := ec2.New(cfg)
+	req := svc.DescribeRegionsRequest(&ec2.DescribeRegionsInput{})
+	resp, err := req.Send()
+	if err != nil {
+		return nil, err
 	}
 
-	instance, err := findInstance(instanceList[idx-1], reservations)
-	if err != nil {
-		printError(err)
+	var regions []string
+	for _, region := range resp.Regions {
+		regions = append(regions, *region.RegionName)
 	}
+	return regions, nil
+}
 
-	return instance
+// regionalClients builds one EC2Client per region, each bound to its own
+// regional endpoint.
+func regionalClients(cfg aws.Config, regions []string) map[string]resolver.EC2Client {
+	clients := make(map[string]resolver.EC2Client, len(regions))
+	for _, region := range regions {
+		regionCfg := cfg.Copy()
+		regionCfg.Region = region
+		clients[region] = &ec2ClientAdapter{svc: ec2.New(regionCfg)}
+	}
+	return clients
 }
 
 func main() {
@@ -214,34 +145,28 @@ func main() {
 		printError(err)
 	}
 
-	svc := ec2.New(cfg)
-
-	var instanceStateFilter = ec2.Filter{
-		Name: aws.String("instance-state-name"),
-		Values: []string{
-			"running",
-			"pending",
-		},
+	regions, err := resolveRegions(cfg)
+	if err != nil {
+		printError(err)
 	}
+	clients := regionalClients(cfg, regions)
+
+	ctx := context.Background()
 
 	if flag.NArg() != 1 {
 		if listInstances {
-			debugf("aws api: describing instances")
-			var params *ec2.DescribeInstancesInput
-
-			params = &ec2.DescribeInstancesInput{
+			params := &ec2.DescribeInstancesInput{
 				Filters: []ec2.Filter{
-					instanceStateFilter,
+					resolver.RunningOrPending,
 				},
 			}
 
-			req := svc.DescribeInstancesRequest(params)
-			resp, err := req.Send()
-			if err != nil {
-				printError(err)
+			regionalResults, errs := resolver.DescribeAllRegions(ctx, clients, params)
+			for _, err := range errs {
+				debugf("aws api: %s", err)
 			}
 
-			printInstanceList(reservationsToInstances(resp.Reservations))
+			printInstanceList(resolver.ReservationsToInstances(regionalResults))
 			os.Exit(0)
 		} else {
 			flag.Usage()
@@ -249,78 +174,57 @@ func main() {
 	}
 
 	lookup := flag.Arg(0)
-	var params *ec2.DescribeInstancesInput
-	if ip := net.ParseIP(lookup); ip != nil {
-		params = &ec2.DescribeInstancesInput{
-			Filters: []ec2.Filter{
-				{
-					Name: aws.String("private-ip-address"),
-					Values: []string{
-						lookup,
-					},
-				},
-				instanceStateFilter,
-			},
-		}
-	} else if instIdRe.MatchString(lookup) {
-		debugf("describing instance(s) by ID")
-		params = &ec2.DescribeInstancesInput{
-			InstanceIds: []string{lookup},
-			Filters: []ec2.Filter{
-				instanceStateFilter,
-			},
-		}
-	} else {
-		debugf("describing instance(s) by name")
-		params = &ec2.DescribeInstancesInput{
-			Filters: []ec2.Filter{
-				{
-					Name:   aws.String("tag:Name"),
-					Values: []string{lookup},
-				},
-				instanceStateFilter,
-			},
-		}
-	}
+	params := resolver.LookupParams(lookup)
 
-	debugf("aws api: describing instances")
-	req := svc.DescribeInstancesRequest(params)
-	resp, err := req.Send()
-	if err != nil {
-		printError(err)
+	regionalResults, errs := resolver.DescribeAllRegions(ctx, clients, params)
+	for _, err := range errs {
+		debugf("aws api: %s", err)
 	}
 
-	debugf("aws api: got %d reservation(s)", len(resp.Reservations))
+	totalReservations := 0
+	for _, region := range regionalResults {
+		totalReservations += len(region.Reservations)
+	}
+	debugf("aws api: got %d reservation(s) across %d region(s)", totalReservations, len(regionalResults))
 
 	var instance *ec2.Instance
-	if len(resp.Reservations) == 0 {
+	var region string
+	if totalReservations == 0 {
 		printError(fmt.Errorf("Found no instance '%s'", lookup))
-	} else if len(resp.Reservations) == 1 {
-		instance = &resp.Reservations[0].Instances[0]
-	} else if len(resp.Reservations) > 1 {
-		instance = chooseInstance(lookup, resp.Reservations)
+	} else if totalReservations == 1 {
+		instance, region = resolver.FindFirstInstance(regionalResults)
+	} else {
+		instance, region, err = resolver.ChooseInstance(os.Stdin, os.Stdout, lookup, regionalResults)
+		if err == resolver.ErrCancelled {
+			os.Exit(0)
+		} else if err != nil {
+			printError(err)
+		}
 	}
 
-	binary, lookErr := exec.LookPath("ssh")
-	if lookErr != nil {
-		printError(lookErr)
+	ec2sshCfg, err := loadConfig()
+	if err != nil {
+		printError(err)
 	}
+	imgCache := newImageCache(cfg)
+	user := resolveUser(instance, region, imgCache, ec2sshCfg)
 
-	args := []string{"-i", keypath(*instance.KeyName), "-l", "ec2-user", *instance.PrivateIpAddress}
-	if verboseFlag {
-		args = append(args, "-v")
-	}
-	if len(remoteCommand) > 1 {
-		args = append(args, remoteCommand)
+	if printUserFlag {
+		fmt.Println(user)
+		os.Exit(0)
 	}
 
-	cmd := exec.Command(binary, args...)
-	cmd.Stdin = os.Stdin
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
+	transport := chooseTransport(instance, region, user)
+
+	if sshTransport, ok := transport.(*SSHTransport); ok {
+		proxyJump, err := resolveProxyJump(ctx, instance, region, clients, imgCache, ec2sshCfg)
+		if err != nil {
+			printError(err)
+		}
+		sshTransport.ProxyJump = proxyJump
+	}
 
-	debugf("running command %v", cmd.Args)
-	if err := cmd.Run(); err != nil {
+	if err := transport.Connect(instance); err != nil {
 		printError(err)
 	}
 }
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"text/tabwriter"
+
+	"github.com/adhocteam/ec2ssh/resolver"
+)
+
+var outputFlag string
+
+// printInstanceList renders instances to stdout in the format selected by
+// the -o/--output flag.
+func printInstanceList(instances []*resolver.Instance) {
+	if len(instances) == 0 {
+		printError(errors.New("Found no instances"))
+		return
+	}
+
+	switch outputFlag {
+	case "wide":
+		printInstanceTableWide(instances)
+	case "json":
+		printInstanceJSON(instances)
+	case "csv":
+		printInstanceCSV(instances)
+	default:
+		printInstanceTable(instances)
+	}
+}
+
+func printInstanceTable(instances []*resolver.Instance) {
+	writer := tabwriter.NewWriter(os.Stdout, 4, 4, 4, ' ', tabwriter.TabIndent)
+	fmt.Fprintln(writer, "Name\tInstance ID\tPrivate IP\tRegion")
+	fmt.Fprintln(writer, "----\t-----------\t----------\t------")
+	for _, instance := range instances {
+		fmt.Fprintf(writer, "%s\t%s\t%s\t%s\n", instance.Name, instance.Id, instance.Ip, instance.Region)
+	}
+	writer.Flush()
+}
+
+func printInstanceTableWide(instances []*resolver.Instance) {
+	writer := tabwriter.NewWriter(os.Stdout, 4, 4, 4, ' ', tabwriter.TabIndent)
+	fmt.Fprintln(writer, "Name\tInstance ID\tPrivate IP\tPublic IP\tRegion\tAZ\tType\tState\tSpot\tLaunch Time")
+	fmt.Fprintln(writer, "----\t-----------\t----------\t---------\t------\t--\t----\t-----\t----\t-----------")
+	for _, instance := range instances {
+		fmt.Fprintf(writer, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			instance.Name, instance.Id, instance.Ip, instance.PublicIp, instance.Region,
+			instance.AZ, instance.Type, instance.State, strconv.FormatBool(instance.Spot), instance.LaunchTime)
+	}
+	writer.Flush()
+}
+
+func printInstanceJSON(instances []*resolver.Instance) {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(instances); err != nil {
+		printError(err)
+	}
+}
+
+func printInstanceCSV(instances []*resolver.Instance) {
+	var buf bytes.Buffer
+	writer := csv.NewWriter(&buf)
+	writer.Write([]string{"name", "id", "private_ip", "public_ip", "region", "az", "type", "state", "spot", "launch_time"})
+	for _, instance := range instances {
+		writer.Write([]string{
+			instance.Name, instance.Id, instance.Ip, instance.PublicIp, instance.Region,
+			instance.AZ, instance.Type, instance.State, strconv.FormatBool(instance.Spot), instance.LaunchTime,
+		})
+	}
+	writer.Flush()
+	os.Stdout.Write(buf.Bytes())
+}
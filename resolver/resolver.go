@@ -0,0 +1,325 @@
+// Package resolver implements instance lookup against EC2: turning a
+// name/instance-id/IP query into a concrete ec2.Instance, fanned out across
+// one EC2Client per region. It depends only on the EC2Client interface, not
+// the concrete AWS SDK client, so it can be exercised in tests with a fake.
+package resolver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+)
+
+// EC2Client is the subset of the EC2 API surface ec2ssh depends on. The real
+// implementation wraps *ec2.Client; tests substitute a fake.
+type EC2Client interface {
+	DescribeInstances(ctx context.Context, input *ec2.DescribeInstancesInput) (*ec2.DescribeInstancesOutput, error)
+}
+
+// InstanceIDRe matches an EC2 instance id, e.g. "i-0123456789abcdef0".
+var InstanceIDRe = regexp.MustCompile(`i-[0-9a-fA-F]{8,17}$`)
+
+// RunningOrPending is the instance-state-name filter every lookup applies.
+var RunningOrPending = ec2.Filter{
+	Name: aws.String("instance-state-name"),
+	Values: []string{
+		"running",
+		"pending",
+	},
+}
+
+// Instance is the lightweight, display-ready projection of an ec2.Instance
+// that ec2ssh's listing and selection UI operates on.
+type Instance struct {
+	Name       string `json:"name"`
+	Id         string `json:"id"`
+	Ip         string `json:"private_ip"`
+	Region     string `json:"region"`
+	Type       string `json:"type"`
+	State      string `json:"state"`
+	AZ         string `json:"az"`
+	PublicIp   string `json:"public_ip,omitempty"`
+	LaunchTime string `json:"launch_time,omitempty"`
+	Spot       bool   `json:"spot"`
+}
+
+type Instances []*Instance
+
+func (s Instances) Len() int {
+	return len(s)
+}
+
+func (s Instances) Swap(i, j int) {
+	s[i], s[j] = s[j], s[i]
+}
+
+func (s Instances) Less(i, j int) bool {
+	switch strings.Compare(s[i].Name, s[j].Name) {
+	case -1:
+		return true
+	case 1:
+		return false
+	}
+	return s[i].Name > s[j].Name
+}
+
+// RegionalReservations pairs a region with the reservations DescribeInstances
+// returned from it, so results from a multi-region fan-out can still be
+// traced back to the regional endpoint they came from.
+type RegionalReservations struct {
+	Region       string
+	Reservations []ec2.RunInstancesOutput
+}
+
+// ErrCancelled is returned by ChooseInstance when the user aborts the
+// interactive prompt (e.g. by sending EOF).
+var ErrCancelled = errors.New("selection cancelled")
+
+// LookupParams builds the DescribeInstancesInput for a user-supplied query,
+// dispatching on whether it looks like a private IP, an instance id, or a
+// Name tag.
+func LookupParams(lookup string) *ec2.DescribeInstancesInput {
+	if ip := net.ParseIP(lookup); ip != nil {
+		return &ec2.DescribeInstancesInput{
+			Filters: []ec2.Filter{
+				{
+					Name:   aws.String("private-ip-address"),
+					Values: []string{lookup},
+				},
+				RunningOrPending,
+			},
+		}
+	}
+
+	if InstanceIDRe.MatchString(lookup) {
+		return &ec2.DescribeInstancesInput{
+			InstanceIds: []string{lookup},
+			Filters: []ec2.Filter{
+				RunningOrPending,
+			},
+		}
+	}
+
+	return &ec2.DescribeInstancesInput{
+		Filters: []ec2.Filter{
+			{
+				Name:   aws.String("tag:Name"),
+				Values: []string{lookup},
+			},
+			RunningOrPending,
+		},
+	}
+}
+
+// regionResult is the per-region outcome of a DescribeInstances fan-out.
+type regionResult struct {
+	region       string
+	reservations []ec2.RunInstancesOutput
+	err          error
+}
+
+// RegionError is a per-region failure from DescribeAllRegions: the region
+// whose DescribeInstances call failed, and why.
+type RegionError struct {
+	Region string
+	Err    error
+}
+
+func (e *RegionError) Error() string {
+	return fmt.Sprintf("describing instances in %s: %s", e.Region, e.Err)
+}
+
+// DescribeAllRegions fans DescribeInstances out across clients concurrently,
+// one goroutine per region, and merges the results. A region whose call
+// fails is skipped rather than failing the whole lookup (another region may
+// still hold the instance being searched for); its error is returned
+// alongside the results so the caller can surface it.
+func DescribeAllRegions(ctx context.Context, clients map[string]EC2Client, params *ec2.DescribeInstancesInput) ([]RegionalReservations, []error) {
+	results := make(chan regionResult, len(clients))
+	var wg sync.WaitGroup
+
+	for region, client := range clients {
+		wg.Add(1)
+		go func(region string, client EC2Client) {
+			defer wg.Done()
+
+			resp, err := client.DescribeInstances(ctx, params)
+			if err != nil {
+				results <- regionResult{region: region, err: err}
+				return
+			}
+			results <- regionResult{region: region, reservations: resp.Reservations}
+		}(region, client)
+	}
+
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	var regionalResults []RegionalReservations
+	var errs []error
+	for result := range results {
+		if result.err != nil {
+			errs = append(errs, &RegionError{Region: result.region, Err: result.err})
+			continue
+		}
+		regionalResults = append(regionalResults, RegionalReservations{
+			Region:       result.region,
+			Reservations: result.reservations,
+		})
+	}
+
+	return regionalResults, errs
+}
+
+// ReservationsToInstances flattens a set of per-region reservations into the
+// sorted, display-ready Instance list.
+func ReservationsToInstances(regions []RegionalReservations) []*Instance {
+	var instances []*Instance
+	for _, region := range regions {
+		for _, reservation := range region.Reservations {
+			for _, instance := range reservation.Instances {
+				name := "[None]"
+				for _, keys := range instance.Tags {
+					if *keys.Key == "Name" {
+						name = url.QueryEscape(*keys.Value)
+					}
+				}
+
+				var az string
+				if instance.Placement != nil && instance.Placement.AvailabilityZone != nil {
+					az = *instance.Placement.AvailabilityZone
+				}
+
+				var publicIp string
+				if instance.PublicIpAddress != nil {
+					publicIp = *instance.PublicIpAddress
+				}
+
+				var launchTime string
+				if instance.LaunchTime != nil {
+					launchTime = instance.LaunchTime.Format(time.RFC3339)
+				}
+
+				var state string
+				if instance.State != nil {
+					state = string(instance.State.Name)
+				}
+
+				var ip string
+				if instance.PrivateIpAddress != nil {
+					ip = *instance.PrivateIpAddress
+				}
+
+				instances = append(instances, &Instance{
+					Name:       name,
+					Id:         *instance.InstanceId,
+					Ip:         ip,
+					Region:     region.Region,
+					Type:       string(instance.InstanceType),
+					State:      state,
+					AZ:         az,
+					PublicIp:   publicIp,
+					LaunchTime: launchTime,
+					Spot:       instance.InstanceLifecycle == ec2.InstanceLifecycleTypeSpot,
+				})
+			}
+		}
+	}
+	sort.Sort(Instances(instances))
+	return instances
+}
+
+// FindFirstInstance returns the only instance (and its region) in a set of
+// per-region reservations known to contain exactly one.
+func FindFirstInstance(regions []RegionalReservations) (*ec2.Instance, string) {
+	for _, region := range regions {
+		for _, reservation := range region.Reservations {
+			if len(reservation.Instances) > 0 {
+				return &reservation.Instances[0], region.Region
+			}
+		}
+	}
+	return nil, ""
+}
+
+// FindInstance returns the ec2.Instance (and the region it was found in)
+// matching instance.Id within regions.
+func FindInstance(instance *Instance, regions []RegionalReservations) (*ec2.Instance, string, error) {
+	for _, region := range regions {
+		for _, reservation := range region.Reservations {
+			for _, ec2Instance := range reservation.Instances {
+				if *ec2Instance.InstanceId == instance.Id {
+					return &ec2Instance, region.Region, nil
+				}
+			}
+		}
+	}
+	return nil, "", fmt.Errorf("Unable to find instance %#v", instance)
+}
+
+// ChooseInstance prints the candidates in regions to w and reads the user's
+// numeric selection from r, returning the chosen ec2.Instance and its
+// region. It returns ErrCancelled if the user aborts the prompt.
+func ChooseInstance(r io.Reader, w io.Writer, lookup string, regions []RegionalReservations) (*ec2.Instance, string, error) {
+	instanceList := ReservationsToInstances(regions)
+
+	fmt.Fprintf(w, `Found more than one instance for '%s'.
+
+Available instances:
+
+%s
+
+Which would you like to connect to? [1]
+>>> `, lookup, fmtInstanceList(instanceList))
+
+	var which string
+	_, err := fmt.Fscanln(r, &which)
+	if err == io.EOF {
+		// We're currently in the middle of a line; print a newline to clean up
+		// the user's terminal
+		fmt.Fprintln(w, "")
+		return nil, "", ErrCancelled
+	}
+
+	idx := 1
+	if len(which) > 0 {
+		idx, err = strconv.Atoi(which)
+		if err != nil {
+			return nil, "", err
+		}
+	}
+
+	if idx < 1 || idx > len(instanceList) {
+		return nil, "", fmt.Errorf("Invalid index %d", idx)
+	}
+
+	return FindInstance(instanceList[idx-1], regions)
+}
+
+// fmtInstanceList formats a slice of instance pointers into a numbered table.
+func fmtInstanceList(instances []*Instance) string {
+	var buf strings.Builder
+	writer := tabwriter.NewWriter(&buf, 4, 4, 4, ' ', tabwriter.TabIndent)
+	fmt.Fprintln(writer, "n\tName\tInstance ID\tPrivate IP\tRegion")
+	fmt.Fprintln(writer, "-\t----\t-----------\t----------\t------")
+	for i, instance := range instances {
+		fmt.Fprintf(writer, "%d\t%s\t%s\t%s\t%s\n", i+1, instance.Name, instance.Id, instance.Ip, instance.Region)
+	}
+	writer.Flush()
+	return buf.String()
+}
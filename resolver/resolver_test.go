@@ -0,0 +1,292 @@
+package resolver_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+
+	"github.com/adhocteam/ec2ssh/resolver"
+)
+
+// fakeEC2Client is an in-memory stand-in for the real EC2 API. It stores
+// instances keyed by instance id and evaluates the subset of filters this
+// tool actually issues: instance-state-name, private-ip-address, and
+// tag:Name.
+type fakeEC2Client struct {
+	instances map[string]*ec2.Instance
+}
+
+func newFakeEC2Client(instances ...*ec2.Instance) *fakeEC2Client {
+	c := &fakeEC2Client{instances: make(map[string]*ec2.Instance)}
+	for _, instance := range instances {
+		c.instances[*instance.InstanceId] = instance
+	}
+	return c
+}
+
+func (c *fakeEC2Client) DescribeInstances(ctx context.Context, input *ec2.DescribeInstancesInput) (*ec2.DescribeInstancesOutput, error) {
+	var matched []ec2.Instance
+
+	for _, instance := range c.instances {
+		if len(input.InstanceIds) > 0 && !contains(input.InstanceIds, *instance.InstanceId) {
+			continue
+		}
+		if !matchesFilters(instance, input.Filters) {
+			continue
+		}
+		matched = append(matched, *instance)
+	}
+
+	var reservations []ec2.RunInstancesOutput
+	if len(matched) > 0 {
+		reservations = append(reservations, ec2.RunInstancesOutput{Instances: matched})
+	}
+
+	return &ec2.DescribeInstancesOutput{Reservations: reservations}, nil
+}
+
+func contains(haystack []string, needle string) bool {
+	for _, s := range haystack {
+		if s == needle {
+			return true
+		}
+	}
+	return false
+}
+
+func matchesFilters(instance *ec2.Instance, filters []ec2.Filter) bool {
+	for _, filter := range filters {
+		switch *filter.Name {
+		case "instance-state-name":
+			if instance.State == nil || !contains(filter.Values, string(instance.State.Name)) {
+				return false
+			}
+		case "private-ip-address":
+			if instance.PrivateIpAddress == nil || !contains(filter.Values, *instance.PrivateIpAddress) {
+				return false
+			}
+		case "tag:Name":
+			if !contains(filter.Values, tagValue(instance, "Name")) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func tagValue(instance *ec2.Instance, key string) string {
+	for _, tag := range instance.Tags {
+		if *tag.Key == key {
+			return *tag.Value
+		}
+	}
+	return ""
+}
+
+func fakeInstance(id, name, ip string) *ec2.Instance {
+	return &ec2.Instance{
+		InstanceId:       aws.String(id),
+		PrivateIpAddress: aws.String(ip),
+		State:            &ec2.InstanceState{Name: ec2.InstanceStateNameRunning},
+		Tags: []ec2.Tag{
+			{Key: aws.String("Name"), Value: aws.String(name)},
+		},
+	}
+}
+
+func TestLookupParams(t *testing.T) {
+	cases := []struct {
+		name   string
+		lookup string
+		want   string // filter name expected on the returned params
+	}{
+		{"ip", "10.0.0.5", "private-ip-address"},
+		{"instance id", "i-0123456789abcdef0", ""},
+		{"name", "web-1", "tag:Name"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			params := resolver.LookupParams(c.lookup)
+			if c.want == "" {
+				if len(params.InstanceIds) != 1 || params.InstanceIds[0] != c.lookup {
+					t.Fatalf("expected InstanceIds to contain %q, got %v", c.lookup, params.InstanceIds)
+				}
+				return
+			}
+			var found bool
+			for _, f := range params.Filters {
+				if *f.Name == c.want {
+					found = true
+				}
+			}
+			if !found {
+				t.Fatalf("expected a %q filter, got %+v", c.want, params.Filters)
+			}
+		})
+	}
+}
+
+func TestDescribeAllRegionsIPLookup(t *testing.T) {
+	client := newFakeEC2Client(fakeInstance("i-1", "web-1", "10.0.0.5"))
+	clients := map[string]resolver.EC2Client{"us-east-1": client}
+
+	params := resolver.LookupParams("10.0.0.5")
+	regions, errs := resolver.DescribeAllRegions(context.Background(), clients, params)
+	if len(errs) != 0 {
+		t.Fatalf("unexpected error(s): %v", errs)
+	}
+
+	instances := resolver.ReservationsToInstances(regions)
+	if len(instances) != 1 || instances[0].Id != "i-1" {
+		t.Fatalf("expected to find i-1, got %+v", instances)
+	}
+}
+
+func TestDescribeAllRegionsMissingInstance(t *testing.T) {
+	client := newFakeEC2Client(fakeInstance("i-1", "web-1", "10.0.0.5"))
+	clients := map[string]resolver.EC2Client{"us-east-1": client}
+
+	params := resolver.LookupParams("nonexistent")
+	regions, errs := resolver.DescribeAllRegions(context.Background(), clients, params)
+	if len(errs) != 0 {
+		t.Fatalf("unexpected error(s): %v", errs)
+	}
+
+	instances := resolver.ReservationsToInstances(regions)
+	if len(instances) != 0 {
+		t.Fatalf("expected no instances, got %+v", instances)
+	}
+}
+
+// erroringEC2Client always fails DescribeInstances, simulating a region
+// that's unreachable or denied.
+type erroringEC2Client struct {
+	err error
+}
+
+func (c *erroringEC2Client) DescribeInstances(ctx context.Context, input *ec2.DescribeInstancesInput) (*ec2.DescribeInstancesOutput, error) {
+	return nil, c.err
+}
+
+func TestDescribeAllRegionsPartialFailure(t *testing.T) {
+	regionErr := errors.New("access denied")
+	clients := map[string]resolver.EC2Client{
+		"us-east-1": newFakeEC2Client(fakeInstance("i-1", "web", "10.0.0.5")),
+		"us-west-2": &erroringEC2Client{err: regionErr},
+	}
+
+	params := resolver.LookupParams("web")
+	regions, errs := resolver.DescribeAllRegions(context.Background(), clients, params)
+
+	if len(errs) != 1 {
+		t.Fatalf("expected 1 error, got %v", errs)
+	}
+	if !strings.Contains(errs[0].Error(), "us-west-2") || !strings.Contains(errs[0].Error(), regionErr.Error()) {
+		t.Fatalf("expected error to mention region and cause, got %q", errs[0].Error())
+	}
+
+	instances := resolver.ReservationsToInstances(regions)
+	if len(instances) != 1 || instances[0].Id != "i-1" {
+		t.Fatalf("expected the healthy region's instance to still come back, got %+v", instances)
+	}
+}
+
+func TestDescribeAllRegionsNameCollision(t *testing.T) {
+	clients := map[string]resolver.EC2Client{
+		"us-east-1": newFakeEC2Client(fakeInstance("i-1", "web", "10.0.0.5")),
+		"us-west-2": newFakeEC2Client(fakeInstance("i-2", "web", "10.0.0.6")),
+	}
+
+	params := resolver.LookupParams("web")
+	regions, errs := resolver.DescribeAllRegions(context.Background(), clients, params)
+	if len(errs) != 0 {
+		t.Fatalf("unexpected error(s): %v", errs)
+	}
+
+	instances := resolver.ReservationsToInstances(regions)
+	if len(instances) != 2 {
+		t.Fatalf("expected both instances named 'web' across regions, got %+v", instances)
+	}
+
+	ids := map[string]bool{instances[0].Id: true, instances[1].Id: true}
+	if !ids["i-1"] || !ids["i-2"] {
+		t.Fatalf("expected i-1 and i-2, got %+v", instances)
+	}
+}
+
+func TestChooseInstance(t *testing.T) {
+	clients := map[string]resolver.EC2Client{
+		"us-east-1": newFakeEC2Client(
+			fakeInstance("i-1", "web", "10.0.0.5"),
+			fakeInstance("i-2", "web", "10.0.0.6"),
+		),
+	}
+
+	params := resolver.LookupParams("web")
+	regions, errs := resolver.DescribeAllRegions(context.Background(), clients, params)
+	if len(errs) != 0 {
+		t.Fatalf("unexpected error(s): %v", errs)
+	}
+
+	var out strings.Builder
+	instance, region, err := resolver.ChooseInstance(strings.NewReader("2\n"), &out, "web", regions)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if region != "us-east-1" {
+		t.Fatalf("expected region us-east-1, got %s", region)
+	}
+
+	instances := resolver.ReservationsToInstances(regions)
+	sortedIds := []string{instances[0].Id, instances[1].Id}
+	if *instance.InstanceId != sortedIds[1] {
+		t.Fatalf("expected selection 2 to pick %s, got %s", sortedIds[1], *instance.InstanceId)
+	}
+}
+
+func TestReservationsToInstancesNoPrivateIP(t *testing.T) {
+	instance := ec2.Instance{
+		InstanceId: aws.String("i-1"),
+		State:      &ec2.InstanceState{Name: ec2.InstanceStateNameRunning},
+	}
+	regions := []resolver.RegionalReservations{
+		{
+			Region:       "us-east-1",
+			Reservations: []ec2.RunInstancesOutput{{Instances: []ec2.Instance{instance}}},
+		},
+	}
+
+	instances := resolver.ReservationsToInstances(regions)
+	if len(instances) != 1 {
+		t.Fatalf("expected 1 instance, got %+v", instances)
+	}
+	if instances[0].Ip != "" {
+		t.Fatalf("expected empty Ip for instance with no private IP, got %q", instances[0].Ip)
+	}
+}
+
+func TestChooseInstanceCancelled(t *testing.T) {
+	clients := map[string]resolver.EC2Client{
+		"us-east-1": newFakeEC2Client(
+			fakeInstance("i-1", "web", "10.0.0.5"),
+			fakeInstance("i-2", "web", "10.0.0.6"),
+		),
+	}
+
+	params := resolver.LookupParams("web")
+	regions, errs := resolver.DescribeAllRegions(context.Background(), clients, params)
+	if len(errs) != 0 {
+		t.Fatalf("unexpected error(s): %v", errs)
+	}
+
+	var out strings.Builder
+	_, _, err := resolver.ChooseInstance(strings.NewReader(""), &out, "web", regions)
+	if err != resolver.ErrCancelled {
+		t.Fatalf("expected ErrCancelled, got %v", err)
+	}
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+)
+
+var viaFlag string
+var ssmForwardFlag string
+
+const ssmTransportTag = "Ec2Ssh:Transport"
+
+func init() {
+	flag.StringVar(&viaFlag, "via", "", "transport to use to reach the instance: ssh or ssm (default: auto)")
+	flag.StringVar(&ssmForwardFlag, "ssm-forward", "", "localPort:remotePort to forward over an SSM session instead of connecting")
+}
+
+// Transport knows how to hand the user's terminal off to a remote instance,
+// whether that's a raw ssh invocation or an SSM Session Manager session.
+type Transport interface {
+	Connect(instance *ec2.Instance) error
+}
+
+// SSHTransport connects over a regular SSH session to the instance's private
+// IP, the tool's original (and still default) behavior. When UseEIC is set,
+// it pushes an ephemeral key via EC2 Instance Connect instead of relying on
+// an on-disk .pem file.
+type SSHTransport struct {
+	Region    string
+	User      string
+	UseEIC    bool
+	ProxyJump string
+}
+
+func (t *SSHTransport) Connect(instance *ec2.Instance) error {
+	binary, err := exec.LookPath("ssh")
+	if err != nil {
+		return err
+	}
+
+	if instance.PrivateIpAddress == nil {
+		return fmt.Errorf("instance %s has no private IP address", *instance.InstanceId)
+	}
+
+	var keyFile string
+	if t.UseEIC {
+		path, cleanup, err := pushEphemeralKey(t.Region, t.User, instance)
+		if err != nil {
+			return err
+		}
+		defer cleanup()
+		keyFile = path
+	} else {
+		if instance.KeyName == nil {
+			return fmt.Errorf("instance %s has no key pair and --eic was not requested", *instance.InstanceId)
+		}
+		keyFile = keypath(*instance.KeyName)
+	}
+
+	args := []string{"-i", keyFile, "-l", t.User}
+	if t.ProxyJump != "" {
+		args = append(args, "-o", "ProxyJump="+t.ProxyJump)
+	}
+	args = append(args, *instance.PrivateIpAddress)
+	if verboseFlag {
+		args = append(args, "-v")
+	}
+	if len(remoteCommand) > 1 {
+		args = append(args, remoteCommand)
+	}
+
+	cmd := exec.Command(binary, args...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	debugf("running command %v", cmd.Args)
+	return cmd.Run()
+}
+
+// SSMTransport connects via AWS Systems Manager Session Manager, for
+// instances with no inbound SSH access.
+type SSMTransport struct {
+	Region string
+}
+
+func (t *SSMTransport) Connect(instance *ec2.Instance) error {
+	binary, err := exec.LookPath("aws")
+	if err != nil {
+		return err
+	}
+
+	args := []string{"ssm", "start-session", "--target", *instance.InstanceId}
+	if t.Region != "" {
+		args = append(args, "--region", t.Region)
+	}
+
+	if ssmForwardFlag != "" {
+		local, remote, err := splitForward(ssmForwardFlag)
+		if err != nil {
+			return err
+		}
+		args = append(args,
+			"--document-name", "AWS-StartPortForwardingSession",
+			"--parameters", fmt.Sprintf("localPortNumber=%s,portNumber=%s", local, remote),
+		)
+	} else if len(remoteCommand) > 1 {
+		args = append(args,
+			"--document-name", "AWS-StartInteractiveCommand",
+			"--parameters", fmt.Sprintf("command=%s", remoteCommand),
+		)
+	}
+
+	cmd := exec.Command(binary, args...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	debugf("running command %v", cmd.Args)
+	return cmd.Run()
+}
+
+// splitForward parses a "localPort:remotePort" --ssm-forward value.
+func splitForward(s string) (local, remote string, err error) {
+	parts := strings.SplitN(s, ":", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", "", fmt.Errorf("invalid --ssm-forward %q, expected localPort:remotePort", s)
+	}
+	return parts[0], parts[1], nil
+}
+
+// chooseTransport picks the Transport to use for instance, honoring the
+// --via flag, then the Ec2Ssh:Transport tag, then falling back to SSM when
+// the instance has no private IP address at all. Whether an existing private
+// IP is reachable from the caller is not checked.
+func chooseTransport(instance *ec2.Instance, region, user string) Transport {
+	switch viaFlag {
+	case "ssh":
+		return &SSHTransport{Region: region, User: user, UseEIC: useEIC(instance)}
+	case "ssm":
+		return &SSMTransport{Region: region}
+	}
+
+	if tag, ok := instanceTag(instance, ssmTransportTag); ok && tag == "ssm" {
+		debugf("%s tag requests ssm transport", ssmTransportTag)
+		return &SSMTransport{Region: region}
+	}
+
+	if instance.PrivateIpAddress == nil {
+		debugf("no private IP address, falling back to ssm transport")
+		return &SSMTransport{Region: region}
+	}
+
+	return &SSHTransport{Region: region, User: user, UseEIC: useEIC(instance)}
+}
+
+// instanceTag returns the value of the named tag on instance, if present.
+func instanceTag(instance *ec2.Instance, key string) (string, bool) {
+	for _, tag := range instance.Tags {
+		if *tag.Key == key {
+			return *tag.Value, true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+)
+
+func TestSplitForward(t *testing.T) {
+	cases := []struct {
+		name       string
+		in         string
+		wantLocal  string
+		wantRemote string
+		wantErr    bool
+	}{
+		{"valid", "8080:80", "8080", "80", false},
+		{"missing colon", "8080", "", "", true},
+		{"empty local", ":80", "", "", true},
+		{"empty remote", "8080:", "", "", true},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			local, remote, err := splitForward(c.in)
+			if c.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error for %q, got none", c.in)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if local != c.wantLocal || remote != c.wantRemote {
+				t.Fatalf("splitForward(%q) = %q, %q; want %q, %q", c.in, local, remote, c.wantLocal, c.wantRemote)
+			}
+		})
+	}
+}
+
+func taggedInstance(ip *string, tags map[string]string) *ec2.Instance {
+	instance := &ec2.Instance{
+		InstanceId:       aws.String("i-1"),
+		PrivateIpAddress: ip,
+	}
+	for k, v := range tags {
+		instance.Tags = append(instance.Tags, ec2.Tag{Key: aws.String(k), Value: aws.String(v)})
+	}
+	return instance
+}
+
+func TestChooseTransport(t *testing.T) {
+	origVia := viaFlag
+	defer func() { viaFlag = origVia }()
+
+	ip := aws.String("10.0.0.5")
+
+	cases := []struct {
+		name     string
+		via      string
+		instance *ec2.Instance
+		want     string // "ssh" or "ssm"
+	}{
+		{"via ssh forces ssh", "ssh", taggedInstance(ip, nil), "ssh"},
+		{"via ssm forces ssm", "ssm", taggedInstance(ip, nil), "ssm"},
+		{"transport tag requests ssm", "", taggedInstance(ip, map[string]string{ssmTransportTag: "ssm"}), "ssm"},
+		{"no private ip falls back to ssm", "", taggedInstance(nil, nil), "ssm"},
+		{"default picks ssh", "", taggedInstance(ip, nil), "ssh"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			viaFlag = c.via
+			transport := chooseTransport(c.instance, "us-east-1", "ec2-user")
+
+			switch c.want {
+			case "ssh":
+				if _, ok := transport.(*SSHTransport); !ok {
+					t.Fatalf("expected *SSHTransport, got %T", transport)
+				}
+			case "ssm":
+				if _, ok := transport.(*SSMTransport); !ok {
+					t.Fatalf("expected *SSMTransport, got %T", transport)
+				}
+			}
+		})
+	}
+}
+
+func TestInstanceTag(t *testing.T) {
+	instance := taggedInstance(nil, map[string]string{"Name": "web-1"})
+
+	if v, ok := instanceTag(instance, "Name"); !ok || v != "web-1" {
+		t.Fatalf("expected Name=web-1, got %q, %v", v, ok)
+	}
+	if _, ok := instanceTag(instance, "Missing"); ok {
+		t.Fatalf("expected Missing tag to be absent")
+	}
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	yaml "gopkg.in/yaml.v2"
+)
+
+var userFlag string
+var printUserFlag bool
+
+const userTag = "Ec2Ssh:User"
+
+func init() {
+	flag.StringVar(&userFlag, "u", "", "SSH user to connect as (default: resolved from tags/config/AMI)")
+	flag.StringVar(&userFlag, "user", "", "SSH user to connect as (default: resolved from tags/config/AMI)")
+	flag.BoolVar(&printUserFlag, "print-user", false, "print the resolved SSH user and exit, without connecting")
+}
+
+// userRule is one entry of the `users:` mapping in ~/.ec2ssh.yaml: a user to
+// connect as when every pattern the rule sets (Name tag, AMI id, platform)
+// matches the instance. Unset patterns match anything.
+type userRule struct {
+	NamePattern     string `yaml:"name"`
+	AMIPattern      string `yaml:"ami"`
+	PlatformPattern string `yaml:"platform"`
+	User            string `yaml:"user"`
+
+	nameRe     *regexp.Regexp
+	amiRe      *regexp.Regexp
+	platformRe *regexp.Regexp
+}
+
+func (r *userRule) compile() error {
+	var err error
+	if r.NamePattern != "" {
+		if r.nameRe, err = regexp.Compile(r.NamePattern); err != nil {
+			return fmt.Errorf("invalid name pattern %q: %s", r.NamePattern, err)
+		}
+	}
+	if r.AMIPattern != "" {
+		if r.amiRe, err = regexp.Compile(r.AMIPattern); err != nil {
+			return fmt.Errorf("invalid ami pattern %q: %s", r.AMIPattern, err)
+		}
+	}
+	if r.PlatformPattern != "" {
+		if r.platformRe, err = regexp.Compile(r.PlatformPattern); err != nil {
+			return fmt.Errorf("invalid platform pattern %q: %s", r.PlatformPattern, err)
+		}
+	}
+	return nil
+}
+
+func (r *userRule) matches(name, amiID, platform string) bool {
+	if r.nameRe != nil && !r.nameRe.MatchString(name) {
+		return false
+	}
+	if r.amiRe != nil && !r.amiRe.MatchString(amiID) {
+		return false
+	}
+	if r.platformRe != nil && !r.platformRe.MatchString(platform) {
+		return false
+	}
+	return true
+}
+
+// ec2sshConfig is the shape of ~/.ec2ssh.yaml: per-instance user mapping
+// rules, plus (from the bastion auto-detection in bastion.go) a VPC id to
+// bastion name/id mapping.
+type ec2sshConfig struct {
+	Users    []*userRule       `yaml:"users"`
+	Bastions map[string]string `yaml:"bastions"`
+}
+
+// loadConfig reads ~/.ec2ssh.yaml. A missing file is not an error; it just
+// means there are no user mapping or bastion rules.
+func loadConfig() (*ec2sshConfig, error) {
+	home := os.Getenv("HOME")
+	if home == "" {
+		return &ec2sshConfig{}, nil
+	}
+
+	data, err := ioutil.ReadFile(filepath.Join(home, ".ec2ssh.yaml"))
+	if os.IsNotExist(err) {
+		return &ec2sshConfig{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var cfg ec2sshConfig
+	if err := yaml.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("parsing ~/.ec2ssh.yaml: %s", err)
+	}
+	for _, rule := range cfg.Users {
+		if err := rule.compile(); err != nil {
+			return nil, fmt.Errorf("~/.ec2ssh.yaml: %s", err)
+		}
+	}
+	return &cfg, nil
+}
+
+// amiNameHeuristics maps common AMI name prefixes to their default login
+// user, used when no tag or config rule resolves one.
+var amiNameHeuristics = []struct {
+	re   *regexp.Regexp
+	user string
+}{
+	{regexp.MustCompile(`(?i)^ubuntu/`), "ubuntu"},
+	{regexp.MustCompile(`(?i)^amzn2?-`), "ec2-user"},
+	{regexp.MustCompile(`(?i)^debian-`), "admin"},
+	{regexp.MustCompile(`(?i)^bitnami-`), "bitnami"},
+	{regexp.MustCompile(`(?i)^centos`), "centos"},
+	{regexp.MustCompile(`(?i)^rhel`), "ec2-user"},
+}
+
+func amiNameHeuristic(amiName string) (string, bool) {
+	for _, h := range amiNameHeuristics {
+		if h.re.MatchString(amiName) {
+			return h.user, true
+		}
+	}
+	return "", false
+}
+
+// imageCache memoizes DescribeImages calls by AMI id for the lifetime of one
+// ec2ssh invocation, so resolving users for a batch of instances doesn't
+// refetch the same AMI over and over.
+type imageCache struct {
+	cfg     aws.Config
+	clients map[string]*ec2.Client
+	images  map[string]*ec2.Image
+}
+
+func newImageCache(cfg aws.Config) *imageCache {
+	return &imageCache{
+		cfg:     cfg,
+		clients: make(map[string]*ec2.Client),
+		images:  make(map[string]*ec2.Image),
+	}
+}
+
+func (c *imageCache) describe(region, imageID string) (*ec2.Image, error) {
+	if image, ok := c.images[imageID]; ok {
+		return image, nil
+	}
+
+	svc, ok := c.clients[region]
+	if !ok {
+		regionCfg := c.cfg.Copy()
+		regionCfg.Region = region
+		svc = ec2.New(regionCfg)
+		c.clients[region] = svc
+	}
+
+	debugf("aws api: describing image %s", imageID)
+	req := svc.DescribeImagesRequest(&ec2.DescribeImagesInput{ImageIds: []string{imageID}})
+	resp, err := req.Send()
+	if err != nil {
+		return nil, err
+	}
+	if len(resp.Images) == 0 {
+		return nil, fmt.Errorf("no such image %s", imageID)
+	}
+
+	image := &resp.Images[0]
+	c.images[imageID] = image
+	return image, nil
+}
+
+// resolveUser picks the SSH login user for instance, trying in order: the
+// -u/--user flag, the Ec2Ssh:User tag, a matching ~/.ec2ssh.yaml rule, an
+// AMI-name heuristic, and finally the "ec2-user" default.
+func resolveUser(instance *ec2.Instance, region string, cache *imageCache, cfg *ec2sshConfig) string {
+	if userFlag != "" {
+		return userFlag
+	}
+
+	if tag, ok := instanceTag(instance, userTag); ok {
+		return tag
+	}
+
+	name, _ := instanceTag(instance, "Name")
+	var amiID string
+	if instance.ImageId != nil {
+		amiID = *instance.ImageId
+	}
+	platform := string(instance.Platform)
+
+	for _, rule := range cfg.Users {
+		if rule.matches(name, amiID, platform) {
+			return rule.User
+		}
+	}
+
+	if amiID != "" {
+		image, err := cache.describe(region, amiID)
+		if err != nil {
+			debugf("describing image %s failed: %s", amiID, err)
+		} else if image.Name != nil {
+			if user, ok := amiNameHeuristic(*image.Name); ok {
+				return user
+			}
+		}
+	}
+
+	return "ec2-user"
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+)
+
+func TestAmiNameHeuristic(t *testing.T) {
+	cases := []struct {
+		name string
+		want string
+		ok   bool
+	}{
+		{"ubuntu/images/hvm-ssd/ubuntu-focal-20.04", "ubuntu", true},
+		{"amzn2-ami-hvm-2.0.20230307.0-x86_64-gp2", "ec2-user", true},
+		{"amzn-ami-hvm-2018.03", "ec2-user", true},
+		{"debian-11-amd64-20230124", "admin", true},
+		{"bitnami-wordpress-6.1.1", "bitnami", true},
+		{"CentOS-7-x86_64", "centos", true},
+		{"RHEL-9.1.0_HVM", "ec2-user", true},
+		{"my-custom-golden-image", "", false},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			user, ok := amiNameHeuristic(c.name)
+			if ok != c.ok {
+				t.Fatalf("amiNameHeuristic(%q) ok = %v, want %v", c.name, ok, c.ok)
+			}
+			if user != c.want {
+				t.Fatalf("amiNameHeuristic(%q) = %q, want %q", c.name, user, c.want)
+			}
+		})
+	}
+}
+
+func TestResolveUser(t *testing.T) {
+	origUserFlag := userFlag
+	defer func() { userFlag = origUserFlag }()
+
+	instance := &ec2.Instance{
+		InstanceId: aws.String("i-1"),
+		Tags: []ec2.Tag{
+			{Key: aws.String("Name"), Value: aws.String("web-1")},
+		},
+	}
+	cache := newImageCache(aws.Config{})
+
+	t.Run("flag wins over everything", func(t *testing.T) {
+		userFlag = "flag-user"
+		defer func() { userFlag = "" }()
+
+		instance := &ec2.Instance{
+			InstanceId: aws.String("i-1"),
+			Tags: []ec2.Tag{
+				{Key: aws.String(userTag), Value: aws.String("tag-user")},
+			},
+		}
+		if got := resolveUser(instance, "us-east-1", cache, &ec2sshConfig{}); got != "flag-user" {
+			t.Fatalf("resolveUser() = %q, want flag-user", got)
+		}
+	})
+
+	t.Run("tag wins over config", func(t *testing.T) {
+		instance := &ec2.Instance{
+			InstanceId: aws.String("i-1"),
+			Tags: []ec2.Tag{
+				{Key: aws.String(userTag), Value: aws.String("tag-user")},
+				{Key: aws.String("Name"), Value: aws.String("web-1")},
+			},
+		}
+		rule := &userRule{NamePattern: `^web-`, User: "config-user"}
+		if err := rule.compile(); err != nil {
+			t.Fatalf("unexpected error compiling rule: %s", err)
+		}
+		cfg := &ec2sshConfig{Users: []*userRule{rule}}
+		if got := resolveUser(instance, "us-east-1", cache, cfg); got != "tag-user" {
+			t.Fatalf("resolveUser() = %q, want tag-user", got)
+		}
+	})
+
+	t.Run("config rule wins over default", func(t *testing.T) {
+		rule := &userRule{NamePattern: `^web-`, User: "config-user"}
+		if err := rule.compile(); err != nil {
+			t.Fatalf("unexpected error compiling rule: %s", err)
+		}
+		cfg := &ec2sshConfig{Users: []*userRule{rule}}
+		if got := resolveUser(instance, "us-east-1", cache, cfg); got != "config-user" {
+			t.Fatalf("resolveUser() = %q, want config-user", got)
+		}
+	})
+
+	t.Run("falls back to default", func(t *testing.T) {
+		cfg := &ec2sshConfig{}
+		if got := resolveUser(instance, "us-east-1", cache, cfg); got != "ec2-user" {
+			t.Fatalf("resolveUser() = %q, want ec2-user", got)
+		}
+	})
+}
+
+func TestUserRuleMatches(t *testing.T) {
+	rule := &userRule{NamePattern: `^web-`, PlatformPattern: "windows"}
+	if err := rule.compile(); err != nil {
+		t.Fatalf("unexpected error compiling rule: %s", err)
+	}
+
+	if !rule.matches("web-1", "", "windows") {
+		t.Fatalf("expected rule to match web-1/windows")
+	}
+	if rule.matches("db-1", "", "windows") {
+		t.Fatalf("expected rule not to match db-1")
+	}
+	if rule.matches("web-1", "", "linux") {
+		t.Fatalf("expected rule not to match linux platform")
+	}
+}